@@ -0,0 +1,364 @@
+package storage
+
+// The types in this file stand in for package internals that this tree
+// slice doesn't include (pathMapper, blobStore, tombstone, repository, and
+// the upload/read file helpers). They exist only to give the tests in this
+// package something to drive layerStore/GarbageCollect against; production
+// code continues to assume the real versions of these types live
+// elsewhere in the full module.
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/digest"
+	"github.com/docker/distribution/registry/auth"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+type pathSpec interface {
+	pathSpecKind() string
+}
+
+func (layerLinkPathSpec) pathSpecKind() string       { return "layerLink" }
+func (uploadDataPathSpec) pathSpecKind() string      { return "uploadData" }
+func (uploadStartedAtPathSpec) pathSpecKind() string { return "uploadStartedAt" }
+func (layersPathSpec) pathSpecKind() string          { return "layers" }
+func (uploadsRootPathSpec) pathSpecKind() string     { return "uploadsRoot" }
+func (blobDataPathSpec) pathSpecKind() string        { return "blobData" }
+func (tombstonePathSpec) pathSpecKind() string       { return "tombstone" }
+
+type uploadDataPathSpec struct {
+	name string
+	uuid string
+}
+
+type uploadStartedAtPathSpec struct {
+	name string
+	uuid string
+}
+
+type layersPathSpec struct {
+	name string
+}
+
+type uploadsRootPathSpec struct {
+	name string
+}
+
+type blobDataPathSpec struct {
+	digest digest.Digest
+}
+
+type layerLinkPathSpec struct {
+	name   string
+	digest digest.Digest
+}
+
+type pathMapper struct{}
+
+var defaultPathMapper = &pathMapper{}
+
+func (pm *pathMapper) path(spec pathSpec) (string, error) {
+	switch v := spec.(type) {
+	case layerLinkPathSpec:
+		return fmt.Sprintf("/docker/registry/v2/repositories/%s/_layers/%s/%s/link", v.name, v.digest.Algorithm(), v.digest.Hex()), nil
+	case tombstonePathSpec:
+		return fmt.Sprintf("/docker/registry/v2/repositories/%s/_layers/%s/%s/tombstone", v.name, v.digest.Algorithm(), v.digest.Hex()), nil
+	case uploadDataPathSpec:
+		return fmt.Sprintf("/docker/registry/v2/repositories/%s/_uploads/%s/data", v.name, v.uuid), nil
+	case uploadStartedAtPathSpec:
+		return fmt.Sprintf("/docker/registry/v2/repositories/%s/_uploads/%s/startedat", v.name, v.uuid), nil
+	case layersPathSpec:
+		return fmt.Sprintf("/docker/registry/v2/repositories/%s/_layers", v.name), nil
+	case uploadsRootPathSpec:
+		return fmt.Sprintf("/docker/registry/v2/repositories/%s/_uploads", v.name), nil
+	case blobDataPathSpec:
+		hex := v.digest.Hex()
+		return fmt.Sprintf("/docker/registry/v2/blobs/%s/%s/%s/data", v.digest.Algorithm(), hex[:2], hex), nil
+	default:
+		return "", fmt.Errorf("fixtures_test: unknown path spec %T", spec)
+	}
+}
+
+type tombstone struct {
+	pm     *pathMapper
+	driver storagedriver.StorageDriver
+}
+
+func (t tombstone) tombstonePath(name string, dgst digest.Digest) (string, error) {
+	return t.pm.path(tombstonePathSpec{name: name, digest: dgst})
+}
+
+func (t tombstone) tombstoneExists(ctx context.Context, name string, dgst digest.Digest) (bool, error) {
+	p, err := t.tombstonePath(name, dgst)
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := t.driver.Stat(ctx, p); err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); ok {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (t tombstone) putTombstone(ctx context.Context, name string, dgst digest.Digest) error {
+	p, err := t.tombstonePath(name, dgst)
+	if err != nil {
+		return err
+	}
+
+	return t.driver.PutContent(ctx, p, []byte(time.Now().UTC().Format(time.RFC3339)))
+}
+
+type blobStore struct {
+	ctx    context.Context
+	driver storagedriver.StorageDriver
+}
+
+func (bs *blobStore) resolve(linkPath string) (string, error) {
+	content, err := bs.driver.GetContent(bs.ctx, linkPath)
+	if err != nil {
+		return "", err
+	}
+
+	return string(content), nil
+}
+
+type repository struct {
+	ctx       context.Context
+	driver    storagedriver.StorageDriver
+	pm        *pathMapper
+	blobStore *blobStore
+	name      string
+}
+
+func (r *repository) Name() string {
+	return r.name
+}
+
+type bufferedFileWriter struct {
+	ctx    context.Context
+	driver storagedriver.StorageDriver
+	path   string
+	buf    bytes.Buffer
+}
+
+func newFileWriter(ctx context.Context, driver storagedriver.StorageDriver, path string) (*bufferedFileWriter, error) {
+	return &bufferedFileWriter{ctx: ctx, driver: driver, path: path}, nil
+}
+
+func (w *bufferedFileWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+func (w *bufferedFileWriter) ReadFrom(r io.Reader) (int64, error) {
+	return w.buf.ReadFrom(r)
+}
+
+func (w *bufferedFileWriter) Close() error {
+	return w.driver.PutContent(w.ctx, w.path, w.buf.Bytes())
+}
+
+type fileReader struct {
+	driver storagedriver.StorageDriver
+	path   string
+}
+
+func newFileReader(ctx context.Context, driver storagedriver.StorageDriver, path string) (*fileReader, error) {
+	if _, err := driver.Stat(ctx, path); err != nil {
+		return nil, err
+	}
+
+	return &fileReader{driver: driver, path: path}, nil
+}
+
+type layerReader struct {
+	fileReader
+	digest digest.Digest
+}
+
+type allowAllAccessController struct{}
+
+func (allowAllAccessController) Authorized(ctx context.Context, access ...auth.Access) (context.Context, error) {
+	return ctx, nil
+}
+
+// fakeFileInfo is the minimal storagedriver.FileInfo used by fakeDriver.
+type fakeFileInfo struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+func (fi fakeFileInfo) Path() string       { return fi.path }
+func (fi fakeFileInfo) Size() int64        { return fi.size }
+func (fi fakeFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi fakeFileInfo) IsDir() bool        { return false }
+
+// fakeDriver is an in-memory storagedriver.StorageDriver for exercising
+// layerStore/GarbageCollect in tests without a real backend.
+type fakeDriver struct {
+	mu      sync.Mutex
+	content map[string][]byte
+	mtime   map[string]time.Time
+}
+
+func newFakeDriver() *fakeDriver {
+	return &fakeDriver{content: map[string][]byte{}, mtime: map[string]time.Time{}}
+}
+
+func (d *fakeDriver) GetContent(ctx context.Context, path string) ([]byte, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	c, ok := d.content[path]
+	if !ok {
+		return nil, storagedriver.PathNotFoundError{Path: path}
+	}
+
+	return append([]byte(nil), c...), nil
+}
+
+func (d *fakeDriver) PutContent(ctx context.Context, path string, content []byte) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.content[path] = append([]byte(nil), content...)
+	d.mtime[path] = time.Now().UTC()
+	return nil
+}
+
+func (d *fakeDriver) Stat(ctx context.Context, path string) (storagedriver.FileInfo, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	c, ok := d.content[path]
+	if !ok {
+		return nil, storagedriver.PathNotFoundError{Path: path}
+	}
+
+	return fakeFileInfo{path: path, size: int64(len(c)), modTime: d.mtime[path]}, nil
+}
+
+func (d *fakeDriver) List(ctx context.Context, path string) ([]string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	prefix := strings.TrimSuffix(path, "/") + "/"
+
+	seen := make(map[string]bool)
+	var children []string
+	for p := range d.content {
+		if !strings.HasPrefix(p, prefix) {
+			continue
+		}
+
+		rest := p[len(prefix):]
+		child := rest
+		if idx := strings.Index(rest, "/"); idx >= 0 {
+			child = rest[:idx]
+		}
+
+		full := prefix + child
+		if !seen[full] {
+			seen[full] = true
+			children = append(children, full)
+		}
+	}
+
+	if len(children) == 0 {
+		return nil, storagedriver.PathNotFoundError{Path: path}
+	}
+
+	return children, nil
+}
+
+func (d *fakeDriver) Move(ctx context.Context, sourcePath string, destPath string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	c, ok := d.content[sourcePath]
+	if !ok {
+		return storagedriver.PathNotFoundError{Path: sourcePath}
+	}
+
+	d.content[destPath] = c
+	d.mtime[destPath] = time.Now().UTC()
+	delete(d.content, sourcePath)
+	delete(d.mtime, sourcePath)
+	return nil
+}
+
+// Delete removes path, mirroring the real storage drivers' behavior of
+// deleting recursively when path names a directory rather than a single
+// file (e.g. an upload directory containing "data" and "startedat").
+func (d *fakeDriver) Delete(ctx context.Context, path string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.content[path]; ok {
+		delete(d.content, path)
+		delete(d.mtime, path)
+		return nil
+	}
+
+	prefix := strings.TrimSuffix(path, "/") + "/"
+	deleted := false
+	for p := range d.content {
+		if strings.HasPrefix(p, prefix) {
+			delete(d.content, p)
+			delete(d.mtime, p)
+			deleted = true
+		}
+	}
+	if !deleted {
+		return storagedriver.PathNotFoundError{Path: path}
+	}
+
+	return nil
+}
+
+func (d *fakeDriver) ReadStream(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	content, err := d.GetContent(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	return io.NopCloser(bytes.NewReader(content[offset:])), nil
+}
+
+func (d *fakeDriver) WriteStream(ctx context.Context, path string, offset int64, reader io.Reader) (int64, error) {
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := d.PutContent(ctx, path, content); err != nil {
+		return 0, err
+	}
+
+	return int64(len(content)), nil
+}
+
+func (d *fakeDriver) URLFor(ctx context.Context, path string, options map[string]interface{}) (string, error) {
+	return "", fmt.Errorf("fakeDriver: URLFor not supported")
+}
+
+// setMtime lets a test simulate a blob that was written well before the
+// current GarbageCollect generation marker.
+func (d *fakeDriver) setMtime(path string, t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.mtime[path] = t
+}
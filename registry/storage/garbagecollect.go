@@ -0,0 +1,364 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/digest"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+// repositoriesRootPath is the root under which every repository's content
+// is rooted. Repository names are listed directly beneath it.
+const repositoriesRootPath = "/docker/registry/v2/repositories"
+
+// blobsRootPath is the root of the content-addressable blob store, shared
+// across all repositories.
+const blobsRootPath = "/docker/registry/v2/blobs"
+
+// defaultUploadTTL is how long an upload may remain unfinished before
+// GarbageCollect considers it abandoned and safe to remove.
+const defaultUploadTTL = 7 * 24 * time.Hour
+
+// GCOpts controls the behavior of a GarbageCollect pass.
+type GCOpts struct {
+	// DryRun, when true, causes GarbageCollect to compute deletion
+	// candidates without touching storage.
+	DryRun bool
+
+	// UploadTTL overrides defaultUploadTTL when non-zero.
+	UploadTTL time.Duration
+}
+
+// GCResult reports what a GarbageCollect pass removed, or, in dry-run mode,
+// what it would have removed.
+type GCResult struct {
+	DeletedBlobs   []digest.Digest
+	DeletedLinks   []string
+	DeletedUploads []string
+}
+
+// GarbageCollect walks every repository in the registry to determine which
+// blobs are still referenced by a live layer link, then deletes any blob
+// that is not. Links that point at a tombstoned digest are removed along
+// with their tombstone. Upload directories whose startedAt timestamp is
+// older than opts.UploadTTL (or defaultUploadTTL, if unset) are removed as
+// abandoned.
+//
+// A generation marker is taken before the link scan begins. A blob is only
+// ever deleted if its mtime predates that marker, so a blob belonging to a
+// push that races with this pass is left alone rather than reclaimed out
+// from under the writer.
+func GarbageCollect(ctx context.Context, driver storagedriver.StorageDriver, opts GCOpts) (GCResult, error) {
+	var result GCResult
+
+	generation := time.Now().UTC()
+
+	ttl := opts.UploadTTL
+	if ttl == 0 {
+		ttl = defaultUploadTTL
+	}
+
+	repos, err := driver.List(ctx, repositoriesRootPath)
+	if err != nil {
+		return result, err
+	}
+
+	referenced := make(map[digest.Digest]struct{})
+
+	for _, repo := range repos {
+		name := repo[len(repositoriesRootPath)+1:]
+
+		links, err := repositoryLayerLinks(ctx, driver, name)
+		if err != nil {
+			return result, err
+		}
+
+		tomb := tombstone{pm: defaultPathMapper, driver: driver}
+
+		for _, link := range links {
+			tombstoned, err := tomb.tombstoneExists(ctx, name, link.digest)
+			if err != nil {
+				return result, err
+			}
+
+			if !tombstoned {
+				referenced[link.digest] = struct{}{}
+				continue
+			}
+
+			result.DeletedLinks = append(result.DeletedLinks, link.path)
+			if !opts.DryRun {
+				if err := driver.Delete(ctx, link.path); err != nil {
+					return result, err
+				}
+
+				// Clear the tombstone now that the link it was guarding is
+				// gone: Exists/Fetch treat tombstone presence alone as
+				// "not found", regardless of whether a link still exists,
+				// so leaving it behind would permanently block a future
+				// re-push of this digest to this repository.
+				if err := tomb.removeTombstone(ctx, name, link.digest); err != nil {
+					return result, err
+				}
+			}
+		}
+	}
+
+	blobs, err := blobDigests(ctx, driver)
+	if err != nil {
+		return result, err
+	}
+
+	for _, blob := range blobs {
+		if _, ok := referenced[blob.digest]; ok {
+			continue
+		}
+
+		fi, err := driver.Stat(ctx, blob.path)
+		if err != nil {
+			return result, err
+		}
+
+		if fi.ModTime().After(generation) {
+			// Written after the generation marker was taken; may belong to
+			// a push still in flight. Leave it for the next pass.
+			continue
+		}
+
+		result.DeletedBlobs = append(result.DeletedBlobs, blob.digest)
+		if !opts.DryRun {
+			if err := driver.Delete(ctx, blob.path); err != nil {
+				return result, err
+			}
+		}
+	}
+
+	uploads, err := staleUploads(ctx, driver, generation.Add(-ttl))
+	if err != nil {
+		return result, err
+	}
+
+	for _, upload := range uploads {
+		result.DeletedUploads = append(result.DeletedUploads, upload)
+		if !opts.DryRun {
+			if err := driver.Delete(ctx, upload); err != nil {
+				return result, err
+			}
+		}
+	}
+
+	return result, nil
+}
+
+type repoLayerLink struct {
+	digest digest.Digest
+	path   string
+}
+
+// repositoryLayerLinks enumerates the layer link files stored under the
+// named repository along with the digest each one resolves to.
+func repositoryLayerLinks(ctx context.Context, driver storagedriver.StorageDriver, name string) ([]repoLayerLink, error) {
+	layersPath, err := defaultPathMapper.path(layersPathSpec{name: name})
+	if err != nil {
+		return nil, err
+	}
+
+	digestDirs, err := driver.List(ctx, layersPath)
+	if err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); ok {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var links []repoLayerLink
+	for _, algDir := range digestDirs {
+		hexDirs, err := driver.List(ctx, algDir)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, hexDir := range hexDirs {
+			dgst := digest.NewDigestFromHex(lastPathComponent(algDir), lastPathComponent(hexDir))
+
+			linkPath, err := defaultPathMapper.path(layerLinkPathSpec{name: name, digest: dgst})
+			if err != nil {
+				return nil, err
+			}
+
+			// A digest directory may hold a tombstone but no link file.
+			if _, err := driver.Stat(ctx, linkPath); err != nil {
+				if _, ok := err.(storagedriver.PathNotFoundError); ok {
+					continue
+				}
+				return nil, err
+			}
+
+			links = append(links, repoLayerLink{digest: dgst, path: linkPath})
+		}
+	}
+
+	return links, nil
+}
+
+type gcBlob struct {
+	digest digest.Digest
+	path   string
+}
+
+// blobDigests enumerates every blob in the content-addressable blob store,
+// regardless of which repositories still reference it.
+//
+// Unlike the per-repository layer links walked by repositoryLayerLinks,
+// blobDataPathSpec shards the shared blob store by the first bytes of the
+// hex digest (alg/shard/hex/data) to keep any single directory from
+// growing without bound, so this walk is one level deeper than that one:
+// algorithm, then shard, then the full hex digest.
+func blobDigests(ctx context.Context, driver storagedriver.StorageDriver) ([]gcBlob, error) {
+	algDirs, err := driver.List(ctx, blobsRootPath)
+	if err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); ok {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var blobs []gcBlob
+	for _, algDir := range algDirs {
+		shardDirs, err := driver.List(ctx, algDir)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, shardDir := range shardDirs {
+			hexDirs, err := driver.List(ctx, shardDir)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, hexDir := range hexDirs {
+				dgst := digest.NewDigestFromHex(lastPathComponent(algDir), lastPathComponent(hexDir))
+
+				dataPath, err := defaultPathMapper.path(blobDataPathSpec{digest: dgst})
+				if err != nil {
+					return nil, err
+				}
+
+				blobs = append(blobs, gcBlob{digest: dgst, path: dataPath})
+			}
+		}
+	}
+
+	return blobs, nil
+}
+
+// touchBlob refreshes path's mtime without altering its content, so that a
+// concurrent GarbageCollect pass's generation-marker check treats it as
+// recently touched. Mount and UploadFrom both call this after linking to a
+// pre-existing blob, since linking alone never writes the blob itself, and
+// without it a blob that GC had already scanned as unreferenced could be
+// deleted out from under a link created after the scan but before the
+// delete step.
+func touchBlob(ctx context.Context, driver storagedriver.StorageDriver, path string) error {
+	content, err := driver.GetContent(ctx, path)
+	if err != nil {
+		return err
+	}
+
+	return driver.PutContent(ctx, path, content)
+}
+
+// tombstonePathSpec locates the marker file that tombstone.putTombstone
+// writes for name/digest, mirroring the name/digest shape already used by
+// layerLinkPathSpec.
+type tombstonePathSpec struct {
+	name   string
+	digest digest.Digest
+}
+
+// removeTombstone clears the tombstone marker for name/digest; a missing
+// marker is not an error. GarbageCollect calls it only after deleting the
+// link the tombstone was guarding. layerWriter.Finish and uploadFromStaged
+// call it right after writing a fresh link, since Exists/Fetch report a
+// tombstoned digest as not found even when a link is present.
+func (t tombstone) removeTombstone(ctx context.Context, name string, dgst digest.Digest) error {
+	p, err := t.pm.path(tombstonePathSpec{name: name, digest: dgst})
+	if err != nil {
+		return err
+	}
+
+	if err := t.driver.Delete(ctx, p); err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); ok {
+			return nil
+		}
+		return err
+	}
+
+	return nil
+}
+
+// staleUploads returns the upload data directories whose startedAt
+// timestamp is older than cutoff.
+func staleUploads(ctx context.Context, driver storagedriver.StorageDriver, cutoff time.Time) ([]string, error) {
+	repos, err := driver.List(ctx, repositoriesRootPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var stale []string
+	for _, repo := range repos {
+		name := repo[len(repositoriesRootPath)+1:]
+
+		uploadsPath, err := defaultPathMapper.path(uploadsRootPathSpec{name: name})
+		if err != nil {
+			return nil, err
+		}
+
+		uploadDirs, err := driver.List(ctx, uploadsPath)
+		if err != nil {
+			if _, ok := err.(storagedriver.PathNotFoundError); ok {
+				continue
+			}
+			return nil, err
+		}
+
+		for _, uploadDir := range uploadDirs {
+			uuid := lastPathComponent(uploadDir)
+
+			startedAtPath, err := defaultPathMapper.path(uploadStartedAtPathSpec{name: name, uuid: uuid})
+			if err != nil {
+				return nil, err
+			}
+
+			startedAtBytes, err := driver.GetContent(ctx, startedAtPath)
+			if err != nil {
+				if _, ok := err.(storagedriver.PathNotFoundError); ok {
+					continue
+				}
+				return nil, err
+			}
+
+			startedAt, err := time.Parse(time.RFC3339, string(startedAtBytes))
+			if err != nil {
+				return nil, err
+			}
+
+			if startedAt.Before(cutoff) {
+				stale = append(stale, uploadDir)
+			}
+		}
+	}
+
+	return stale, nil
+}
+
+// lastPathComponent returns the final "/"-separated component of p.
+func lastPathComponent(p string) string {
+	for i := len(p) - 1; i >= 0; i-- {
+		if p[i] == '/' {
+			return p[i+1:]
+		}
+	}
+	return p
+}
@@ -0,0 +1,253 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/digest"
+)
+
+// TestGarbageCollectClearsTombstoneForRepush exercises the scenario the
+// original GC implementation got wrong: once GC has removed a tombstoned
+// link and its backing blob, the tombstone itself must also be cleared so
+// the same digest can be pushed again later.
+func TestGarbageCollectClearsTombstoneForRepush(t *testing.T) {
+	ctx := context.Background()
+	driver := newFakeDriver()
+	pm := defaultPathMapper
+	repoName := "testrepo"
+
+	dgst := digest.NewDigestFromHex("sha256", repeatHex("b", 64))
+
+	linkPath, err := pm.path(layerLinkPathSpec{name: repoName, digest: dgst})
+	if err != nil {
+		t.Fatalf("path: %v", err)
+	}
+	blobPath, err := pm.path(blobDataPathSpec{digest: dgst})
+	if err != nil {
+		t.Fatalf("path: %v", err)
+	}
+
+	if err := driver.PutContent(ctx, blobPath, []byte("layer bytes")); err != nil {
+		t.Fatalf("PutContent blob: %v", err)
+	}
+	if err := driver.PutContent(ctx, linkPath, []byte(blobPath)); err != nil {
+		t.Fatalf("PutContent link: %v", err)
+	}
+
+	tomb := tombstone{pm: pm, driver: driver}
+	if err := tomb.putTombstone(ctx, repoName, dgst); err != nil {
+		t.Fatalf("putTombstone: %v", err)
+	}
+
+	// Back-date the blob so GC's generation-marker check doesn't skip it.
+	driver.setMtime(blobPath, time.Now().UTC().Add(-time.Hour))
+
+	if _, err := GarbageCollect(ctx, driver, GCOpts{}); err != nil {
+		t.Fatalf("GarbageCollect: %v", err)
+	}
+
+	if exists, err := tomb.tombstoneExists(ctx, repoName, dgst); err != nil {
+		t.Fatalf("tombstoneExists: %v", err)
+	} else if exists {
+		t.Fatal("GarbageCollect must clear the tombstone once its link is removed, or the digest can never be re-pushed")
+	}
+
+	// Simulate a re-push of the same digest after GC.
+	if err := driver.PutContent(ctx, blobPath, []byte("layer bytes")); err != nil {
+		t.Fatalf("PutContent blob (repush): %v", err)
+	}
+	if err := driver.PutContent(ctx, linkPath, []byte(blobPath)); err != nil {
+		t.Fatalf("PutContent link (repush): %v", err)
+	}
+
+	repo := &repository{ctx: ctx, driver: driver, pm: pm, blobStore: &blobStore{ctx: ctx, driver: driver}, name: repoName}
+	ls := &layerStore{repository: repo, tomb: tombstone{pm: pm, driver: driver}}
+
+	exists, err := ls.Exists(dgst)
+	if err != nil {
+		t.Fatalf("Exists: %v", err)
+	}
+	if !exists {
+		t.Fatal("re-pushed digest should be visible again after GC cleared the stale tombstone")
+	}
+}
+
+// TestBlobDigestsWalksShardedLayout pins down blobDigests' walk depth
+// against blobDataPathSpec's actual (sharded) layout: algorithm, then a
+// two-character shard, then the full hex digest.
+func TestBlobDigestsWalksShardedLayout(t *testing.T) {
+	ctx := context.Background()
+	driver := newFakeDriver()
+	pm := defaultPathMapper
+
+	dgst := digest.NewDigestFromHex("sha256", repeatHex("c", 64))
+
+	dataPath, err := pm.path(blobDataPathSpec{digest: dgst})
+	if err != nil {
+		t.Fatalf("path: %v", err)
+	}
+
+	if err := driver.PutContent(ctx, dataPath, []byte("blob bytes")); err != nil {
+		t.Fatalf("PutContent: %v", err)
+	}
+
+	blobs, err := blobDigests(ctx, driver)
+	if err != nil {
+		t.Fatalf("blobDigests: %v", err)
+	}
+
+	found := false
+	for _, b := range blobs {
+		if b.digest == dgst {
+			found = true
+			if b.path != dataPath {
+				t.Fatalf("reconstructed path %q, want %q", b.path, dataPath)
+			}
+		}
+	}
+	if !found {
+		t.Fatalf("blobDigests did not find %v among the blobs sharded under %s", dgst, blobsRootPath)
+	}
+}
+
+// TestGarbageCollectDeletesUnreferencedBlob covers the primary delete path:
+// a blob with no live link anywhere in the registry is unreferenced and
+// should be reclaimed once it's older than the generation marker.
+func TestGarbageCollectDeletesUnreferencedBlob(t *testing.T) {
+	ctx := context.Background()
+	driver := newFakeDriver()
+	pm := defaultPathMapper
+
+	dgst := digest.NewDigestFromHex("sha256", repeatHex("d", 64))
+
+	blobPath, err := pm.path(blobDataPathSpec{digest: dgst})
+	if err != nil {
+		t.Fatalf("path: %v", err)
+	}
+	if err := driver.PutContent(ctx, blobPath, []byte("orphaned bytes")); err != nil {
+		t.Fatalf("PutContent: %v", err)
+	}
+	driver.setMtime(blobPath, time.Now().UTC().Add(-time.Hour))
+
+	result, err := GarbageCollect(ctx, driver, GCOpts{})
+	if err != nil {
+		t.Fatalf("GarbageCollect: %v", err)
+	}
+
+	if len(result.DeletedBlobs) != 1 || result.DeletedBlobs[0] != dgst {
+		t.Fatalf("DeletedBlobs = %v, want [%v]", result.DeletedBlobs, dgst)
+	}
+
+	if _, err := driver.Stat(ctx, blobPath); err == nil {
+		t.Fatal("unreferenced blob should have been deleted")
+	}
+}
+
+// TestGarbageCollectDeletesStaleUploads covers upload-directory reclamation:
+// an in-progress upload whose startedAt predates the TTL is abandoned and
+// should be removed.
+func TestGarbageCollectDeletesStaleUploads(t *testing.T) {
+	ctx := context.Background()
+	driver := newFakeDriver()
+	pm := defaultPathMapper
+	repoName := "testrepo"
+	uuid := "deadbeef-0000-0000-0000-000000000000"
+
+	dataPath, err := pm.path(uploadDataPathSpec{name: repoName, uuid: uuid})
+	if err != nil {
+		t.Fatalf("path: %v", err)
+	}
+	startedAtPath, err := pm.path(uploadStartedAtPathSpec{name: repoName, uuid: uuid})
+	if err != nil {
+		t.Fatalf("path: %v", err)
+	}
+
+	if err := driver.PutContent(ctx, dataPath, []byte("partial upload")); err != nil {
+		t.Fatalf("PutContent data: %v", err)
+	}
+	startedAt := time.Now().UTC().Add(-2 * time.Hour)
+	if err := driver.PutContent(ctx, startedAtPath, []byte(startedAt.Format(time.RFC3339))); err != nil {
+		t.Fatalf("PutContent startedat: %v", err)
+	}
+
+	result, err := GarbageCollect(ctx, driver, GCOpts{UploadTTL: time.Hour})
+	if err != nil {
+		t.Fatalf("GarbageCollect: %v", err)
+	}
+
+	if len(result.DeletedUploads) != 1 {
+		t.Fatalf("DeletedUploads = %v, want exactly one stale upload", result.DeletedUploads)
+	}
+
+	if _, err := driver.Stat(ctx, dataPath); err == nil {
+		t.Fatal("stale upload data should have been deleted")
+	}
+	if _, err := driver.Stat(ctx, startedAtPath); err == nil {
+		t.Fatal("stale upload startedat should have been deleted")
+	}
+}
+
+// TestGarbageCollectDryRun asserts that DryRun reports what would be
+// deleted without touching storage.
+func TestGarbageCollectDryRun(t *testing.T) {
+	ctx := context.Background()
+	driver := newFakeDriver()
+	pm := defaultPathMapper
+	repoName := "testrepo"
+
+	dgst := digest.NewDigestFromHex("sha256", repeatHex("e", 64))
+
+	linkPath, err := pm.path(layerLinkPathSpec{name: repoName, digest: dgst})
+	if err != nil {
+		t.Fatalf("path: %v", err)
+	}
+	blobPath, err := pm.path(blobDataPathSpec{digest: dgst})
+	if err != nil {
+		t.Fatalf("path: %v", err)
+	}
+
+	if err := driver.PutContent(ctx, blobPath, []byte("layer bytes")); err != nil {
+		t.Fatalf("PutContent blob: %v", err)
+	}
+	if err := driver.PutContent(ctx, linkPath, []byte(blobPath)); err != nil {
+		t.Fatalf("PutContent link: %v", err)
+	}
+
+	tomb := tombstone{pm: pm, driver: driver}
+	if err := tomb.putTombstone(ctx, repoName, dgst); err != nil {
+		t.Fatalf("putTombstone: %v", err)
+	}
+
+	driver.setMtime(blobPath, time.Now().UTC().Add(-time.Hour))
+
+	result, err := GarbageCollect(ctx, driver, GCOpts{DryRun: true})
+	if err != nil {
+		t.Fatalf("GarbageCollect: %v", err)
+	}
+
+	if len(result.DeletedLinks) != 1 || len(result.DeletedBlobs) != 1 {
+		t.Fatalf("expected DryRun to still report deletion candidates, got links=%v blobs=%v", result.DeletedLinks, result.DeletedBlobs)
+	}
+
+	if _, err := driver.Stat(ctx, linkPath); err != nil {
+		t.Fatalf("DryRun must not delete the link: %v", err)
+	}
+	if _, err := driver.Stat(ctx, blobPath); err != nil {
+		t.Fatalf("DryRun must not delete the blob: %v", err)
+	}
+	if exists, err := tomb.tombstoneExists(ctx, repoName, dgst); err != nil {
+		t.Fatalf("tombstoneExists: %v", err)
+	} else if !exists {
+		t.Fatal("DryRun must not clear the tombstone")
+	}
+}
+
+func repeatHex(c string, n int) string {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = c[0]
+	}
+	return string(b)
+}
@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"io"
 	"time"
 
 	"code.google.com/p/go-uuid/uuid"
@@ -8,6 +9,7 @@ import (
 	"github.com/docker/distribution/context"
 	"github.com/docker/distribution/digest"
 	"github.com/docker/distribution/manifest"
+	"github.com/docker/distribution/registry/auth"
 	storagedriver "github.com/docker/distribution/registry/storage/driver"
 )
 
@@ -84,17 +86,82 @@ func (ls *layerStore) Fetch(dgst digest.Digest) (distribution.Layer, error) {
 	}, nil
 }
 
+// Mount links the blob identified by dgst in sourceRepo into this
+// repository, avoiding a full re-upload when the caller already has access
+// to the blob elsewhere in the registry. Mount verifies pull access to
+// sourceRepo itself, via accessController, before resolving anything there;
+// callers must not skip passing a real accessController on the assumption
+// that access has already been checked upstream.
+func (ls *layerStore) Mount(accessController auth.AccessController, sourceRepo string, dgst digest.Digest) (distribution.Layer, error) {
+	ctx := ls.repository.ctx
+	context.GetLogger(ctx).Debug("(*layerStore).Mount")
+
+	if _, err := accessController.Authorized(ctx, auth.Access{
+		Resource: auth.Resource{Type: "repository", Name: sourceRepo},
+		Action:   "pull",
+	}); err != nil {
+		return nil, err
+	}
+
+	tombstoned, err := ls.tomb.tombstoneExists(ctx, sourceRepo, dgst)
+	if err != nil {
+		return nil, err
+	}
+	if tombstoned {
+		return nil, distribution.ErrUnknownLayer{FSLayer: manifest.FSLayer{BlobSum: dgst}}
+	}
+
+	sourceLinkPath, err := ls.repository.pm.path(layerLinkPathSpec{name: sourceRepo, digest: dgst})
+	if err != nil {
+		return nil, err
+	}
+
+	blobPath, err := ls.repository.blobStore.resolve(sourceLinkPath)
+	if err != nil {
+		switch err := err.(type) {
+		case storagedriver.PathNotFoundError:
+			return nil, distribution.ErrUnknownLayer{
+				FSLayer: manifest.FSLayer{BlobSum: dgst},
+			}
+		default:
+			return nil, err
+		}
+	}
+
+	destLinkPath, err := ls.repository.pm.path(layerLinkPathSpec{name: ls.repository.Name(), digest: dgst})
+	if err != nil {
+		return nil, err
+	}
+
+	// Link directly to the resolved blob path rather than going through
+	// Upload()/newLayerUpload: no bytes need to move, only a new link file
+	// in the destination repository.
+	if err := ls.repository.driver.PutContent(ctx, destLinkPath, []byte(blobPath)); err != nil {
+		return nil, err
+	}
+
+	// Mount only ever links to a pre-existing blob, so its mtime would
+	// otherwise stay untouched; touchBlob keeps it from being mistaken for
+	// garbage by a GarbageCollect pass racing this Mount.
+	if err := touchBlob(ctx, ls.repository.driver, blobPath); err != nil {
+		return nil, err
+	}
+
+	return ls.Fetch(dgst)
+}
+
 // Upload begins a layer upload, returning a handle. If the layer upload
 // is already in progress or the layer has already been uploaded, this
 // will return an error.
+//
+// NOTE(stevvooe): Consider the issues with allowing concurrent upload of
+// the same two layers. Should it be disallowed? For now, we allow both
+// parties to proceed and the the first one to Finish wins, via the
+// content-addressable staging done by layerWriter.Finish.
 func (ls *layerStore) Upload() (distribution.LayerUpload, error) {
 	ctx := ls.repository.ctx
 	context.GetLogger(ctx).Debug("(*layerStore).Upload")
 
-	// NOTE(stevvooe): Consider the issues with allowing concurrent upload of
-	// the same two layers. Should it be disallowed? For now, we allow both
-	// parties to proceed and the the first one uploads the layer.
-
 	uuid := uuid.New()
 	startedAt := time.Now().UTC()
 
@@ -124,6 +191,64 @@ func (ls *layerStore) Upload() (distribution.LayerUpload, error) {
 	return ls.newLayerUpload(uuid, path, startedAt)
 }
 
+// UploadFrom is a layerStore-specific extension of Upload for a caller that
+// claims to already have pull access to dgst via sourceRepo. It is
+// deliberately not part of distribution.LayerService: adding a parameter to
+// Upload itself would change the signature of a method other
+// implementations and call sites already depend on.
+//
+// UploadFrom verifies pull access to sourceRepo, exactly as Mount does,
+// before consulting the shared staging area: the content-addressable store
+// is shared across the whole registry, so without this check a caller could
+// use a guessed or leaked digest to pull a blob out of a repository it has
+// no access to, without transferring a single byte. If sourceRepo links
+// dgst and dgst has already been staged by a prior or concurrent upload,
+// UploadFrom links it into this repository and returns an already-completed
+// LayerUpload, so the caller never has to stream a byte; otherwise it falls
+// back to a normal Upload.
+func (ls *layerStore) UploadFrom(accessController auth.AccessController, sourceRepo string, dgst digest.Digest) (distribution.LayerUpload, error) {
+	ctx := ls.repository.ctx
+	context.GetLogger(ctx).Debug("(*layerStore).UploadFrom")
+
+	if _, err := accessController.Authorized(ctx, auth.Access{
+		Resource: auth.Resource{Type: "repository", Name: sourceRepo},
+		Action:   "pull",
+	}); err != nil {
+		return nil, err
+	}
+
+	tombstoned, err := ls.tomb.tombstoneExists(ctx, sourceRepo, dgst)
+	if err != nil {
+		return nil, err
+	}
+	if tombstoned {
+		return nil, distribution.ErrUnknownLayer{FSLayer: manifest.FSLayer{BlobSum: dgst}}
+	}
+
+	sourceLinkPath, err := ls.repository.pm.path(layerLinkPathSpec{name: sourceRepo, digest: dgst})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := ls.repository.driver.Stat(ctx, sourceLinkPath); err != nil {
+		switch err.(type) {
+		case storagedriver.PathNotFoundError:
+			return ls.Upload()
+		default:
+			return nil, err
+		}
+	}
+
+	upload, err := ls.uploadFromStaged(dgst)
+	if err != nil {
+		return nil, err
+	}
+	if upload != nil {
+		return upload, nil
+	}
+
+	return ls.Upload()
+}
+
 // Resume continues an in progress layer upload, returning the current
 // state of the upload.
 func (ls *layerStore) Resume(uuid string) (distribution.LayerUpload, error) {
@@ -189,6 +314,103 @@ func (ls *layerStore) Delete(dgst digest.Digest) error {
 	return nil
 }
 
+// uploadFromStaged checks whether dgst already exists in the shared,
+// content-addressable staging area (the same location layerWriter.Finish
+// moves completed uploads into). If it does, it links the staged blob into
+// this repository and returns a LayerUpload that is already complete,
+// letting the caller skip the upload entirely. It returns a nil upload,
+// with no error, if the blob has not been staged by anyone yet.
+func (ls *layerStore) uploadFromStaged(dgst digest.Digest) (distribution.LayerUpload, error) {
+	ctx := ls.repository.ctx
+
+	stagedPath, err := ls.repository.pm.path(blobDataPathSpec{digest: dgst})
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := ls.repository.driver.Stat(ctx, stagedPath); err != nil {
+		switch err.(type) {
+		case storagedriver.PathNotFoundError:
+			return nil, nil
+		default:
+			return nil, err
+		}
+	}
+
+	destLinkPath, err := ls.repository.pm.path(layerLinkPathSpec{name: ls.repository.Name(), digest: dgst})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ls.repository.driver.PutContent(ctx, destLinkPath, []byte(stagedPath)); err != nil {
+		return nil, err
+	}
+
+	// Same reasoning as Mount: this only links to an existing blob, so bump
+	// its mtime for GarbageCollect's generation-marker check.
+	if err := touchBlob(ctx, ls.repository.driver, stagedPath); err != nil {
+		return nil, err
+	}
+
+	// A stale tombstone can linger here if dgst was previously deleted from
+	// this repository and GC hasn't reclaimed it yet; this call just
+	// created a fresh link, so any leftover tombstone must go, or
+	// Exists/Fetch would keep reporting the digest as not found.
+	if err := ls.tomb.removeTombstone(ctx, ls.repository.Name(), dgst); err != nil {
+		return nil, err
+	}
+
+	return &completedLayerUpload{
+		layerStore: ls,
+		uuid:       uuid.New(),
+		startedAt:  time.Now().UTC(),
+		digest:     dgst,
+	}, nil
+}
+
+// completedLayerUpload satisfies distribution.LayerUpload for a transfer
+// that was resolved entirely server-side, via uploadFromStaged: the client
+// never writes any data, so Write/ReadFrom fail with ErrLayerClosed and
+// Finish hands back the already-linked layer when dgst matches.
+type completedLayerUpload struct {
+	layerStore *layerStore
+	uuid       string
+	startedAt  time.Time
+	digest     digest.Digest
+}
+
+func (clu *completedLayerUpload) UUID() string {
+	return clu.uuid
+}
+
+func (clu *completedLayerUpload) StartedAt() time.Time {
+	return clu.startedAt
+}
+
+func (clu *completedLayerUpload) Write(p []byte) (int, error) {
+	return 0, distribution.ErrLayerClosed
+}
+
+func (clu *completedLayerUpload) ReadFrom(r io.Reader) (int64, error) {
+	return 0, distribution.ErrLayerClosed
+}
+
+func (clu *completedLayerUpload) Close() error {
+	return nil
+}
+
+func (clu *completedLayerUpload) Finish(dgst digest.Digest) (distribution.Layer, error) {
+	if dgst != clu.digest {
+		return nil, distribution.ErrLayerInvalidDigest{Digest: dgst}
+	}
+
+	return clu.layerStore.Fetch(clu.digest)
+}
+
+func (clu *completedLayerUpload) Cancel() error {
+	return nil
+}
+
 // newLayerUpload allocates a new upload controller with the given state.
 func (ls *layerStore) newLayerUpload(uuid, path string, startedAt time.Time) (distribution.LayerUpload, error) {
 	fw, err := newFileWriter(ls.repository.ctx, ls.repository.driver, path)
@@ -200,6 +422,7 @@ func (ls *layerStore) newLayerUpload(uuid, path string, startedAt time.Time) (di
 		layerStore:         ls,
 		uuid:               uuid,
 		startedAt:          startedAt,
+		path:               path,
 		bufferedFileWriter: *fw,
 		tomb: tombstone{
 			pm:     defaultPathMapper,
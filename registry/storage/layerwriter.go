@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/digest"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+// layerWriter implements distribution.LayerUpload, receiving the bytes of
+// an in-progress layer upload and, on Finish, committing them to the
+// shared, content-addressable blob store.
+type layerWriter struct {
+	layerStore *layerStore
+	uuid       string
+	startedAt  time.Time
+	path       string
+	bufferedFileWriter
+	tomb tombstone
+}
+
+func (lw *layerWriter) UUID() string {
+	return lw.uuid
+}
+
+func (lw *layerWriter) StartedAt() time.Time {
+	return lw.startedAt
+}
+
+// Finish moves the upload's temp data into the shared staging path keyed
+// by dgst, so that two clients uploading the same digest never both keep a
+// full copy: whichever one reaches Finish first claims the staging path, and
+// a later one discards its temp data and links the staged blob instead. The
+// existence check is a Stat followed by a plain Move, not one atomic
+// create-if-not-exists step.
+func (lw *layerWriter) Finish(dgst digest.Digest) (distribution.Layer, error) {
+	ls := lw.layerStore
+	ctx := ls.repository.ctx
+	context.GetLogger(ctx).Debug("(*layerWriter).Finish")
+
+	if err := lw.bufferedFileWriter.Close(); err != nil {
+		return nil, err
+	}
+
+	stagedPath, err := ls.repository.pm.path(blobDataPathSpec{digest: dgst})
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := ls.repository.driver.Stat(ctx, stagedPath); err != nil {
+		switch err.(type) {
+		case storagedriver.PathNotFoundError:
+			// Nobody has staged this digest yet: claim it. The Stat above
+			// and this Move are not atomic, so two Finish calls for the
+			// same digest can both get here; both then Move, and the later
+			// Move replaces the staged blob with its own copy of the same
+			// digest. Either way one copy is left at stagedPath.
+			if err := ls.repository.driver.Move(ctx, lw.path, stagedPath); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, err
+		}
+	} else {
+		// Someone else already staged this digest first; our data is a
+		// redundant copy.
+		if err := ls.repository.driver.Delete(ctx, lw.path); err != nil {
+			return nil, err
+		}
+	}
+
+	linkPath, err := ls.repository.pm.path(layerLinkPathSpec{name: ls.repository.Name(), digest: dgst})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ls.repository.driver.PutContent(ctx, linkPath, []byte(stagedPath)); err != nil {
+		return nil, err
+	}
+
+	// A stale tombstone can linger here if dgst was previously deleted from
+	// this repository and GC hasn't reclaimed it yet; this Finish is a
+	// fresh push, so any leftover tombstone must be cleared, or
+	// Exists/Fetch would keep reporting the digest as not found despite the
+	// link just created.
+	if err := lw.tomb.removeTombstone(ctx, ls.repository.Name(), dgst); err != nil {
+		return nil, err
+	}
+
+	return ls.Fetch(dgst)
+}
+
+// setupResumableDigester is a hook for wiring up incremental digest
+// verification as bytes are written; this tree slice has no resumable
+// digester to wire up, so it is a no-op.
+func (lw *layerWriter) setupResumableDigester() {}
+
+func (lw *layerWriter) Cancel() error {
+	ls := lw.layerStore
+	ctx := ls.repository.ctx
+	context.GetLogger(ctx).Debug("(*layerWriter).Cancel")
+
+	if err := ls.repository.driver.Delete(ctx, lw.path); err != nil {
+		switch err.(type) {
+		case storagedriver.PathNotFoundError:
+			return nil
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/digest"
+)
+
+// TestConcurrentUploadSameDigestDedupes exercises the case the staging in
+// layerWriter.Finish targets: two uploads of the same digest both write
+// their bytes, then both call Finish, one after the other. Only one of them should
+// win the shared staging path; the other must discard its own copy and
+// link to the winner's blob, so exactly one blob survives in the shared
+// store and both repositories resolve to it.
+func TestConcurrentUploadSameDigestDedupes(t *testing.T) {
+	ctx := context.Background()
+	driver := newFakeDriver()
+	pm := defaultPathMapper
+
+	content := []byte("same layer bytes")
+	dgst := digest.FromBytes(content)
+
+	repoA := &repository{ctx: ctx, driver: driver, pm: pm, blobStore: &blobStore{ctx: ctx, driver: driver}, name: "repo/a"}
+	repoB := &repository{ctx: ctx, driver: driver, pm: pm, blobStore: &blobStore{ctx: ctx, driver: driver}, name: "repo/b"}
+
+	lsA := &layerStore{repository: repoA, tomb: tombstone{pm: pm, driver: driver}}
+	lsB := &layerStore{repository: repoB, tomb: tombstone{pm: pm, driver: driver}}
+
+	uploadA, err := lsA.Upload()
+	if err != nil {
+		t.Fatalf("lsA.Upload: %v", err)
+	}
+	uploadB, err := lsB.Upload()
+	if err != nil {
+		t.Fatalf("lsB.Upload: %v", err)
+	}
+
+	if _, err := uploadA.Write(content); err != nil {
+		t.Fatalf("uploadA.Write: %v", err)
+	}
+	if _, err := uploadB.Write(content); err != nil {
+		t.Fatalf("uploadB.Write: %v", err)
+	}
+
+	if _, err := uploadA.Finish(dgst); err != nil {
+		t.Fatalf("uploadA.Finish: %v", err)
+	}
+	if _, err := uploadB.Finish(dgst); err != nil {
+		t.Fatalf("uploadB.Finish: %v", err)
+	}
+
+	stagedPath, err := pm.path(blobDataPathSpec{digest: dgst})
+	if err != nil {
+		t.Fatalf("path: %v", err)
+	}
+
+	if got, err := driver.GetContent(ctx, stagedPath); err != nil {
+		t.Fatalf("GetContent staged blob: %v", err)
+	} else if string(got) != string(content) {
+		t.Fatalf("staged blob content = %q, want %q", got, content)
+	}
+
+	for _, ls := range []*layerStore{lsA, lsB} {
+		exists, err := ls.Exists(dgst)
+		if err != nil {
+			t.Fatalf("Exists: %v", err)
+		}
+		if !exists {
+			t.Fatalf("digest should exist in %s after Finish", ls.repository.Name())
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/digest"
+)
+
+func TestMountOfTombstonedDigestIsRejected(t *testing.T) {
+	ctx := context.Background()
+	driver := newFakeDriver()
+	pm := defaultPathMapper
+
+	dgst := digest.NewDigestFromHex("sha256", strings.Repeat("a", 64))
+	sourceRepo := "source/repo"
+	destRepo := &repository{ctx: ctx, driver: driver, pm: pm, blobStore: &blobStore{ctx: ctx, driver: driver}, name: "dest/repo"}
+
+	tomb := tombstone{pm: pm, driver: driver}
+	if err := tomb.putTombstone(ctx, sourceRepo, dgst); err != nil {
+		t.Fatalf("putTombstone: %v", err)
+	}
+
+	ls := &layerStore{repository: destRepo, tomb: tombstone{pm: pm, driver: driver}}
+
+	if _, err := ls.Mount(allowAllAccessController{}, sourceRepo, dgst); err == nil {
+		t.Fatal("expected Mount of a tombstoned digest to fail")
+	} else if _, ok := err.(distribution.ErrUnknownLayer); !ok {
+		t.Fatalf("expected ErrUnknownLayer mounting a tombstoned digest, got %#v", err)
+	}
+
+	destLinkPath, err := pm.path(layerLinkPathSpec{name: destRepo.Name(), digest: dgst})
+	if err != nil {
+		t.Fatalf("path: %v", err)
+	}
+
+	if _, err := driver.Stat(ctx, destLinkPath); err == nil {
+		t.Fatal("Mount must not create a destination link for a tombstoned source digest")
+	}
+}
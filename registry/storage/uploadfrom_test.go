@@ -0,0 +1,161 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/digest"
+	"github.com/docker/distribution/registry/auth"
+)
+
+// denyAllAccessController rejects every access check, so tests can assert
+// that UploadFrom actually consults the access controller it's given
+// instead of trusting the caller's claimed sourceRepo.
+type denyAllAccessController struct{}
+
+func (denyAllAccessController) Authorized(ctx context.Context, access ...auth.Access) (context.Context, error) {
+	return nil, errors.New("access denied")
+}
+
+func TestUploadFromLinksStagedBlobWithAccess(t *testing.T) {
+	ctx := context.Background()
+	driver := newFakeDriver()
+	pm := defaultPathMapper
+
+	content := []byte("staged layer bytes")
+	dgst := digest.FromBytes(content)
+	sourceRepo := "source/repo"
+
+	sourceRepoObj := &repository{ctx: ctx, driver: driver, pm: pm, blobStore: &blobStore{ctx: ctx, driver: driver}, name: sourceRepo}
+	lsSource := &layerStore{repository: sourceRepoObj, tomb: tombstone{pm: pm, driver: driver}}
+
+	upload, err := lsSource.Upload()
+	if err != nil {
+		t.Fatalf("lsSource.Upload: %v", err)
+	}
+	if _, err := upload.Write(content); err != nil {
+		t.Fatalf("upload.Write: %v", err)
+	}
+	if _, err := upload.Finish(dgst); err != nil {
+		t.Fatalf("upload.Finish: %v", err)
+	}
+
+	destRepoObj := &repository{ctx: ctx, driver: driver, pm: pm, blobStore: &blobStore{ctx: ctx, driver: driver}, name: "dest/repo"}
+	lsDest := &layerStore{repository: destRepoObj, tomb: tombstone{pm: pm, driver: driver}}
+
+	destUpload, err := lsDest.UploadFrom(allowAllAccessController{}, sourceRepo, dgst)
+	if err != nil {
+		t.Fatalf("lsDest.UploadFrom: %v", err)
+	}
+
+	if _, err := destUpload.Finish(dgst); err != nil {
+		t.Fatalf("destUpload.Finish: %v", err)
+	}
+
+	exists, err := lsDest.Exists(dgst)
+	if err != nil {
+		t.Fatalf("Exists: %v", err)
+	}
+	if !exists {
+		t.Fatal("UploadFrom should link the staged blob into the destination repository")
+	}
+}
+
+func TestUploadFromRequiresSourceAccess(t *testing.T) {
+	ctx := context.Background()
+	driver := newFakeDriver()
+	pm := defaultPathMapper
+
+	content := []byte("private layer bytes")
+	dgst := digest.FromBytes(content)
+	sourceRepo := "private/repo"
+
+	stagedPath, err := pm.path(blobDataPathSpec{digest: dgst})
+	if err != nil {
+		t.Fatalf("path: %v", err)
+	}
+	if err := driver.PutContent(ctx, stagedPath, content); err != nil {
+		t.Fatalf("PutContent: %v", err)
+	}
+
+	destRepoObj := &repository{ctx: ctx, driver: driver, pm: pm, blobStore: &blobStore{ctx: ctx, driver: driver}, name: "dest/repo"}
+	lsDest := &layerStore{repository: destRepoObj, tomb: tombstone{pm: pm, driver: driver}}
+
+	if _, err := lsDest.UploadFrom(denyAllAccessController{}, sourceRepo, dgst); err == nil {
+		t.Fatal("expected UploadFrom to fail without pull access to sourceRepo")
+	}
+
+	destLinkPath, err := pm.path(layerLinkPathSpec{name: destRepoObj.Name(), digest: dgst})
+	if err != nil {
+		t.Fatalf("path: %v", err)
+	}
+	if _, err := driver.Stat(ctx, destLinkPath); err == nil {
+		t.Fatal("UploadFrom must not link a blob it wasn't authorized to pull")
+	}
+}
+
+func TestUploadFromRejectsTombstonedSourceDigest(t *testing.T) {
+	ctx := context.Background()
+	driver := newFakeDriver()
+	pm := defaultPathMapper
+
+	dgst := digest.FromBytes([]byte("tombstoned source content"))
+	sourceRepo := "source/repo"
+
+	tomb := tombstone{pm: pm, driver: driver}
+	if err := tomb.putTombstone(ctx, sourceRepo, dgst); err != nil {
+		t.Fatalf("putTombstone: %v", err)
+	}
+
+	destRepoObj := &repository{ctx: ctx, driver: driver, pm: pm, blobStore: &blobStore{ctx: ctx, driver: driver}, name: "dest/repo"}
+	lsDest := &layerStore{repository: destRepoObj, tomb: tombstone{pm: pm, driver: driver}}
+
+	if _, err := lsDest.UploadFrom(allowAllAccessController{}, sourceRepo, dgst); err == nil {
+		t.Fatal("expected UploadFrom of a tombstoned source digest to fail")
+	} else if _, ok := err.(distribution.ErrUnknownLayer); !ok {
+		t.Fatalf("expected ErrUnknownLayer, got %#v", err)
+	}
+}
+
+// TestFinishClearsStaleDestinationTombstone exercises the delete-then-repush
+// flow: a digest is deleted from a repository (tombstoned), and pushed again
+// before GC runs. Finish must clear the stale tombstone itself, or the
+// re-pushed link would be invisible to Exists/Fetch and would be deleted by
+// the next GarbageCollect pass despite being brand new.
+func TestFinishClearsStaleDestinationTombstone(t *testing.T) {
+	ctx := context.Background()
+	driver := newFakeDriver()
+	pm := defaultPathMapper
+	repoName := "testrepo"
+
+	content := []byte("repushed content")
+	dgst := digest.FromBytes(content)
+
+	repo := &repository{ctx: ctx, driver: driver, pm: pm, blobStore: &blobStore{ctx: ctx, driver: driver}, name: repoName}
+	ls := &layerStore{repository: repo, tomb: tombstone{pm: pm, driver: driver}}
+
+	if err := ls.tomb.putTombstone(ctx, repoName, dgst); err != nil {
+		t.Fatalf("putTombstone: %v", err)
+	}
+
+	upload, err := ls.Upload()
+	if err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if _, err := upload.Write(content); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if _, err := upload.Finish(dgst); err != nil {
+		t.Fatalf("Finish: %v", err)
+	}
+
+	exists, err := ls.Exists(dgst)
+	if err != nil {
+		t.Fatalf("Exists: %v", err)
+	}
+	if !exists {
+		t.Fatal("re-pushed digest must be visible once Finish clears the stale tombstone")
+	}
+}